@@ -0,0 +1,338 @@
+package ktail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// rawLineFunc is invoked once per raw log line read from a tailed container,
+// before the Controller's filter chain turns it into a LogEvent.
+type rawLineFunc func(pod *v1.Pod, container *v1.Container, line []byte)
+
+const (
+	// initContainerPollInterval is how often Run polls for an init
+	// container to reach Running or Terminated before opening its log
+	// stream.
+	initContainerPollInterval = 500 * time.Millisecond
+
+	// maxConsecutiveStreamFailures bounds how many reconnect attempts in a
+	// row are allowed to fail outright (as opposed to ending in a clean
+	// EOF) before runWithReconnect gives up and returns the error, rather
+	// than retrying a non-recoverable failure (bad container name, a 403,
+	// a pod that's gone) forever.
+	maxConsecutiveStreamFailures = 10
+)
+
+// reconnectBackoffBase and reconnectBackoffMax bound the exponential backoff
+// a regular container's tailer applies between reconnects. Variables rather
+// than consts so tests can shrink them instead of waiting out a real backoff.
+var (
+	reconnectBackoffBase = 1 * time.Second
+	reconnectBackoffMax  = 30 * time.Second
+)
+
+// errTailerStopped signals that Stop was called, or ctx was canceled, while
+// Run was waiting on something other than the log stream itself.
+var errTailerStopped = errors.New("ktail: tailer stopped")
+
+// ContainerTailer streams logs for a single container of a single pod until
+// Stop is called, ctx is canceled, or (for init containers only) the
+// container terminates.
+type ContainerTailer struct {
+	sync.Mutex
+	ctx           context.Context
+	clientset     kubernetes.Interface
+	pod           v1.Pod
+	container     v1.Container
+	onEvent       rawLineFunc
+	tailFromStart bool
+	isInit        bool
+	isEphemeral   bool
+	sinceTime     time.Time
+	onReconnect   func(attempt int, err error)
+	onProgress    func(ts time.Time)
+	stopCh        chan struct{}
+	stopped       bool
+}
+
+// NewContainerTailer builds a tailer for the given pod/container pair.
+// ctx is checked alongside Stop to end the stream, so canceling the
+// Controller's context stops every tailer it started without each needing an
+// explicit Stop call. When tailFromStart is false, streaming begins from the
+// current time rather than replaying everything the container has already
+// logged; sinceTime, when non-zero, takes precedence over tailFromStart and
+// seeds a resume point left behind by an earlier tailer for this container.
+// isInit marks container as one of pod.Spec.InitContainers: Run waits for it
+// to start before streaming and returns once it terminates rather than
+// reconnecting forever. isEphemeral marks container as an ephemeral debug
+// container. onReconnect, if non-nil, is called before every reconnect
+// attempt with a 1-based attempt number and the error that ended the
+// previous stream (nil if it just hit a clean EOF); onProgress, if non-nil,
+// is called with the timestamp of the most recently delivered line so a
+// caller can persist a high-water mark.
+func NewContainerTailer(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	pod v1.Pod,
+	container v1.Container,
+	onEvent rawLineFunc,
+	tailFromStart bool,
+	isInit bool,
+	isEphemeral bool,
+	sinceTime time.Time,
+	onReconnect func(attempt int, err error),
+	onProgress func(ts time.Time)) *ContainerTailer {
+	return &ContainerTailer{
+		ctx:           ctx,
+		clientset:     clientset,
+		pod:           pod,
+		container:     container,
+		onEvent:       onEvent,
+		tailFromStart: tailFromStart,
+		isInit:        isInit,
+		isEphemeral:   isEphemeral,
+		sinceTime:     sinceTime,
+		onReconnect:   onReconnect,
+		onProgress:    onProgress,
+		stopCh:        make(chan struct{}),
+	}
+}
+
+// Run opens the log stream and blocks, delivering lines to onEvent, until
+// Stop is called, ctx is canceled, or -- for an init container -- the
+// container terminates. For any other container, a dropped stream (network
+// blip, apiserver restart) is reconnected with exponential backoff, resuming
+// from the last delivered line instead of replaying from the start. If
+// maxConsecutiveStreamFailures reconnect attempts in a row fail outright --
+// as opposed to ending in a clean EOF -- Run gives up and returns the last
+// error instead of retrying a non-recoverable failure forever.
+func (t *ContainerTailer) Run() error {
+	if t.isInit {
+		terminated, err := t.waitForInitContainer()
+		if err != nil {
+			if err == errTailerStopped {
+				return nil
+			}
+			return err
+		}
+		// Follow only if the container is still producing logs; otherwise
+		// fetch what it already logged and return without retrying.
+		return t.streamOnce(!terminated, t.sinceTime)
+	}
+	return t.runWithReconnect()
+}
+
+func (t *ContainerTailer) runWithReconnect() error {
+	since := t.sinceTime
+	attempt := 0
+	consecutiveFailures := 0
+	for {
+		err := t.streamOnce(true, since)
+
+		select {
+		case <-t.stopCh:
+			return nil
+		case <-t.ctx.Done():
+			return nil
+		default:
+		}
+
+		if err != nil {
+			consecutiveFailures++
+			if consecutiveFailures >= maxConsecutiveStreamFailures {
+				return err
+			}
+		} else {
+			consecutiveFailures = 0
+		}
+
+		if last := t.lastDelivered(); !last.IsZero() {
+			since = last
+		}
+
+		attempt++
+		if t.onReconnect != nil {
+			t.onReconnect(attempt, err)
+		}
+		if !t.sleepBackoff(attempt) {
+			return nil
+		}
+	}
+}
+
+// streamOnce opens the log stream once and blocks, delivering lines, until
+// the stream ends, Stop is called, or ctx is canceled. since, when non-zero,
+// resumes the stream from just after that timestamp; otherwise tailFromStart
+// decides whether to replay the container's whole history.
+func (t *ContainerTailer) streamOnce(follow bool, since time.Time) error {
+	opts := &v1.PodLogOptions{
+		Container:  t.container.Name,
+		Follow:     follow,
+		Timestamps: true,
+	}
+	switch {
+	case !since.IsZero():
+		sinceTime := metav1.NewTime(since)
+		opts.SinceTime = &sinceTime
+	case !t.tailFromStart:
+		now := metav1.Now()
+		opts.SinceTime = &now
+	}
+
+	stream, err := t.clientset.CoreV1().Pods(t.pod.Namespace).GetLogs(t.pod.Name, opts).Stream(t.ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	reader := bufio.NewReader(stream)
+	for {
+		select {
+		case <-t.stopCh:
+			return nil
+		case <-t.ctx.Done():
+			return nil
+		default:
+		}
+
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			t.deliver(line, since)
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// deliver strips the leading RFC3339Nano timestamp the kubelet prefixes to
+// each line (requested via PodLogOptions.Timestamps), drops the line if its
+// timestamp doesn't come after boundary -- eliminating the duplicate line a
+// sinceTime reconnect would otherwise replay -- and forwards the rest to
+// onEvent.
+func (t *ContainerTailer) deliver(line []byte, boundary time.Time) {
+	ts, rest, ok := splitTimestamp(line)
+	if ok {
+		if !boundary.IsZero() && !ts.After(boundary) {
+			return
+		}
+		t.recordDelivered(ts)
+		line = rest
+	}
+	t.onEvent(&t.pod, &t.container, line)
+}
+
+func splitTimestamp(line []byte) (ts time.Time, rest []byte, ok bool) {
+	idx := bytes.IndexByte(line, ' ')
+	if idx < 0 {
+		return time.Time{}, line, false
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, string(line[:idx]))
+	if err != nil {
+		return time.Time{}, line, false
+	}
+	return parsed, line[idx+1:], true
+}
+
+func (t *ContainerTailer) recordDelivered(ts time.Time) {
+	t.Lock()
+	t.sinceTime = ts
+	t.Unlock()
+	if t.onProgress != nil {
+		t.onProgress(ts)
+	}
+}
+
+func (t *ContainerTailer) lastDelivered() time.Time {
+	t.Lock()
+	defer t.Unlock()
+	return t.sinceTime
+}
+
+// backoffCap returns the upper bound for the full-jitter delay before
+// reconnect attempt attempt, doubling with each attempt and clamping at
+// reconnectBackoffMax -- including when the doubling itself overflows.
+func backoffCap(attempt int) time.Duration {
+	delay := reconnectBackoffBase << uint(attempt-1)
+	if delay <= 0 || delay > reconnectBackoffMax {
+		delay = reconnectBackoffMax
+	}
+	return delay
+}
+
+// sleepBackoff waits out an exponential backoff (full jitter) before the
+// next reconnect attempt, returning false if Stop/ctx fired first.
+func (t *ContainerTailer) sleepBackoff(attempt int) bool {
+	delay := time.Duration(rand.Int63n(int64(backoffCap(attempt)) + 1))
+
+	select {
+	case <-t.stopCh:
+		return false
+	case <-t.ctx.Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+// waitForInitContainer blocks until the init container this tailer is bound
+// to is Running (returns terminated=false) or has already exited
+// (terminated=true), or until Stop is called.
+func (t *ContainerTailer) waitForInitContainer() (terminated bool, err error) {
+	for {
+		select {
+		case <-t.stopCh:
+			return false, errTailerStopped
+		case <-t.ctx.Done():
+			return false, errTailerStopped
+		default:
+		}
+
+		pod, err := t.clientset.CoreV1().Pods(t.pod.Namespace).Get(t.ctx, t.pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, status := range pod.Status.InitContainerStatuses {
+			if status.Name != t.container.Name {
+				continue
+			}
+			if status.State.Terminated != nil {
+				return true, nil
+			}
+			if status.State.Running != nil {
+				return false, nil
+			}
+		}
+
+		select {
+		case <-t.stopCh:
+			return false, errTailerStopped
+		case <-t.ctx.Done():
+			return false, errTailerStopped
+		case <-time.After(initContainerPollInterval):
+		}
+	}
+}
+
+// Stop terminates the stream. It is safe to call more than once.
+func (t *ContainerTailer) Stop() {
+	t.Lock()
+	defer t.Unlock()
+	if !t.stopped {
+		t.stopped = true
+		close(t.stopCh)
+	}
+}
@@ -0,0 +1,210 @@
+package ktail
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func testClientset(t *testing.T, server *httptest.Server) kubernetes.Interface {
+	t.Helper()
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("kubernetes.NewForConfig: %v", err)
+	}
+	return clientset
+}
+
+func TestDeliverDedupesAcrossSinceTimeBoundary(t *testing.T) {
+	var mu sync.Mutex
+	var delivered []string
+
+	tailer := &ContainerTailer{
+		pod:       v1.Pod{},
+		container: v1.Container{},
+		onEvent: func(pod *v1.Pod, container *v1.Container, line []byte) {
+			mu.Lock()
+			delivered = append(delivered, string(line))
+			mu.Unlock()
+		},
+		stopCh: make(chan struct{}),
+	}
+
+	boundary := time.Unix(1700000000, 0)
+	before := boundary.Format(time.RFC3339Nano) + " already seen\n"
+	after := boundary.Add(time.Second).Format(time.RFC3339Nano) + " new line\n"
+
+	tailer.deliver([]byte(before), boundary)
+	tailer.deliver([]byte(after), boundary)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(delivered) != 1 || delivered[0] != "new line\n" {
+		t.Fatalf("got %v, want only the line after the sinceTime boundary delivered", delivered)
+	}
+}
+
+func TestDeliverWithZeroBoundaryDeliversEverything(t *testing.T) {
+	var delivered []string
+	tailer := &ContainerTailer{
+		onEvent: func(pod *v1.Pod, container *v1.Container, line []byte) {
+			delivered = append(delivered, string(line))
+		},
+		stopCh: make(chan struct{}),
+	}
+
+	ts := time.Unix(1700000000, 0).Format(time.RFC3339Nano)
+	tailer.deliver([]byte(ts+" first\n"), time.Time{})
+	tailer.deliver([]byte(ts+" second\n"), time.Time{})
+
+	if len(delivered) != 2 {
+		t.Fatalf("got %v, want both lines delivered with no boundary set", delivered)
+	}
+}
+
+// TestRunWithReconnectGivesUpAfterMaxConsecutiveFailures points a real
+// clientset at a server that always fails the log request, and checks that
+// Run gives up after exactly maxConsecutiveStreamFailures attempts instead of
+// retrying a non-recoverable failure forever, reporting each attempt via
+// onReconnect.
+func TestRunWithReconnectGivesUpAfterMaxConsecutiveFailures(t *testing.T) {
+	origBase, origMax := reconnectBackoffBase, reconnectBackoffMax
+	reconnectBackoffBase = time.Millisecond
+	reconnectBackoffMax = 5 * time.Millisecond
+	defer func() { reconnectBackoffBase, reconnectBackoffMax = origBase, origMax }()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	var mu sync.Mutex
+	var attempts []int
+	tailer := NewContainerTailer(
+		context.Background(),
+		testClientset(t, server),
+		v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "pod"}},
+		v1.Container{Name: "app"},
+		func(pod *v1.Pod, container *v1.Container, line []byte) {},
+		true, false, false, time.Time{},
+		func(attempt int, err error) {
+			mu.Lock()
+			attempts = append(attempts, attempt)
+			mu.Unlock()
+			if err == nil {
+				t.Error("onReconnect called with a nil error for a failed stream")
+			}
+		},
+		nil,
+	)
+
+	done := make(chan error, 1)
+	go func() { done <- tailer.Run() }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Run returned nil, want the last stream error after exhausting retries")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not give up after maxConsecutiveStreamFailures")
+	}
+
+	if got := int(atomic.LoadInt32(&requests)); got != maxConsecutiveStreamFailures {
+		t.Errorf("server saw %d requests, want exactly %d", got, maxConsecutiveStreamFailures)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(attempts) != maxConsecutiveStreamFailures-1 {
+		t.Errorf("onReconnect called %d times, want %d (no reconnect announced before the final, unretried failure)",
+			len(attempts), maxConsecutiveStreamFailures-1)
+	}
+	for i, attempt := range attempts {
+		if attempt != i+1 {
+			t.Errorf("attempts[%d] = %d, want %d", i, attempt, i+1)
+		}
+	}
+}
+
+// TestRunWithReconnectResumesFromLastDeliveredLine checks that a stream that
+// is cut off after emitting some lines reconnects with a sinceTime set to the
+// last line actually delivered, not from the very start -- verified here via
+// the PodLogOptions.SinceTime query parameter the reconnect request carries.
+func TestRunWithReconnectResumesFromLastDeliveredLine(t *testing.T) {
+	origBase, origMax := reconnectBackoffBase, reconnectBackoffMax
+	reconnectBackoffBase = time.Millisecond
+	reconnectBackoffMax = 5 * time.Millisecond
+	defer func() { reconnectBackoffBase, reconnectBackoffMax = origBase, origMax }()
+
+	firstLineTime := time.Unix(1700000000, 0)
+	var sinceTimes []string
+	var mu sync.Mutex
+	var call int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sinceTimes = append(sinceTimes, r.URL.Query().Get("sinceTime"))
+		mu.Unlock()
+
+		n := atomic.AddInt32(&call, 1)
+		if n == 1 {
+			fmt.Fprintf(w, "%s one line then EOF\n", firstLineTime.Format(time.RFC3339Nano))
+			return
+		}
+		// Second connection: its sinceTime (checked below) should resume from
+		// firstLineTime; the line served here makes the test's onEvent cancel
+		// ctx, stopping the stream for good.
+		fmt.Fprintf(w, "%s second connection's line\n", firstLineTime.Add(time.Second).Format(time.RFC3339Nano))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var delivered int32
+	tailer := NewContainerTailer(
+		ctx,
+		testClientset(t, server),
+		v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "pod"}},
+		v1.Container{Name: "app"},
+		func(pod *v1.Pod, container *v1.Container, line []byte) {
+			if atomic.AddInt32(&delivered, 1) == 2 {
+				cancel()
+			}
+		},
+		true, false, false, time.Time{},
+		nil, nil,
+	)
+
+	done := make(chan error, 1)
+	go func() { done <- tailer.Run() }()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after ctx was canceled")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sinceTimes) < 2 {
+		t.Fatalf("server saw %d requests, want at least 2 (initial + reconnect)", len(sinceTimes))
+	}
+	if sinceTimes[0] != "" {
+		t.Errorf("first request's sinceTime = %q, want empty (tailFromStart)", sinceTimes[0])
+	}
+	// metav1.Time is sent as an RFC3339 (whole-second) query parameter.
+	if want := firstLineTime.UTC().Format(time.RFC3339); sinceTimes[1] != want {
+		t.Errorf("reconnect request's sinceTime = %q, want %q (the last delivered line's timestamp)", sinceTimes[1], want)
+	}
+}
@@ -0,0 +1,104 @@
+package ktail
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSplitTimestamp(t *testing.T) {
+	cases := []struct {
+		name    string
+		line    string
+		wantOK  bool
+		wantTS  time.Time
+		wantRst string
+	}{
+		{
+			name:    "timestamped line",
+			line:    "2026-07-29T12:00:00.000000001Z hello world\n",
+			wantOK:  true,
+			wantTS:  time.Date(2026, 7, 29, 12, 0, 0, 1, time.UTC),
+			wantRst: "hello world\n",
+		},
+		{
+			name:    "no space",
+			line:    "noTimestampHere\n",
+			wantOK:  false,
+			wantRst: "noTimestampHere\n",
+		},
+		{
+			name:    "space but not a timestamp",
+			line:    "hello world\n",
+			wantOK:  false,
+			wantRst: "hello world\n",
+		},
+		{
+			name:    "empty line",
+			line:    "",
+			wantOK:  false,
+			wantRst: "",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts, rest, ok := splitTimestamp([]byte(tc.line))
+			if ok != tc.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tc.wantOK)
+			}
+			if ok && !ts.Equal(tc.wantTS) {
+				t.Errorf("ts = %v, want %v", ts, tc.wantTS)
+			}
+			if string(rest) != tc.wantRst {
+				t.Errorf("rest = %q, want %q", rest, tc.wantRst)
+			}
+		})
+	}
+}
+
+func TestBackoffCap(t *testing.T) {
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: reconnectBackoffBase},
+		{attempt: 2, want: 2 * reconnectBackoffBase},
+		{attempt: 6, want: reconnectBackoffMax},
+		// A large enough attempt overflows the bit shift into a negative
+		// or zero value; it must still clamp to reconnectBackoffMax
+		// rather than producing an undefined delay.
+		{attempt: 64, want: reconnectBackoffMax},
+	}
+
+	for _, tc := range cases {
+		if got := backoffCap(tc.attempt); got != tc.want {
+			t.Errorf("backoffCap(%d) = %v, want %v", tc.attempt, got, tc.want)
+		}
+	}
+}
+
+func TestSleepBackoffStopsOnStop(t *testing.T) {
+	tailer := &ContainerTailer{
+		ctx:    context.Background(),
+		stopCh: make(chan struct{}),
+	}
+	close(tailer.stopCh)
+
+	if tailer.sleepBackoff(1) {
+		t.Fatal("sleepBackoff returned true after Stop closed stopCh")
+	}
+}
+
+func TestSleepBackoffStopsOnContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tailer := &ContainerTailer{
+		ctx:    ctx,
+		stopCh: make(chan struct{}),
+	}
+
+	if tailer.sleepBackoff(1) {
+		t.Fatal("sleepBackoff returned true after ctx was canceled")
+	}
+}
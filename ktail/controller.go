@@ -1,111 +1,447 @@
 package ktail
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 )
 
 type (
-	ContainerEnterFunc func(pod *v1.Pod, container *v1.Container) bool
-	ContainerExitFunc  func(pod *v1.Pod, container *v1.Container)
-	ContainerErrorFunc func(pod *v1.Pod, container *v1.Container, err error)
+	ContainerEnterFunc   func(pod *v1.Pod, container *v1.Container, isInit, isEphemeral bool) bool
+	ContainerExitFunc    func(pod *v1.Pod, container *v1.Container)
+	ContainerErrorFunc   func(pod *v1.Pod, container *v1.Container, err error)
+	ContainerRestartFunc func(pod *v1.Pod, container *v1.Container, oldContainerID, newContainerID string, restartCount int32)
+	ReconnectFunc        func(pod *v1.Pod, container *v1.Container, attempt int, err error)
 )
 
 type Callbacks struct {
-	OnEvent LogEventFunc
-	OnEnter ContainerEnterFunc
-	OnExit  ContainerExitFunc
-	OnError ContainerErrorFunc
+	OnEvent     LogEventFunc
+	OnEnter     ContainerEnterFunc
+	OnExit      ContainerExitFunc
+	OnError     ContainerErrorFunc
+	OnRestart   ContainerRestartFunc
+	OnReconnect ReconnectFunc
 }
 
+// containerState is the slice of a container's status the controller needs
+// to notice a restart, an image roll, or a brand new container instance.
+type containerState struct {
+	containerID  string
+	restartCount int32
+}
+
+// offsetPersistEvery bounds how often recordHighWaterMark writes through to
+// the OffsetStore. The in-memory high-water mark is updated on every
+// delivered line so a reconnect always resumes from the right place, but a
+// disk-backed OffsetStore would otherwise take a write per line across every
+// tailed container; the store itself is only touched every offsetPersistEvery
+// lines per container.
+const offsetPersistEvery = 20
+
+// PodObserver is notified of a pod event RunContext's informers saw --
+// letting other components (e.g. ktail/discovery.Registry) ride the same
+// watch instead of opening a redundant one of their own against the same
+// apiserver. The informers list and watch only pods matching the
+// Controller's labelSelector, so an observer sees no other pods.
+type PodObserver func(pod *v1.Pod)
+
 type Controller struct {
 	sync.Mutex
-	clientset     *kubernetes.Clientset
-	tailers       map[string]*ContainerTailer
-	namespace     string
-	labelSelector labels.Selector
-	callbacks     Callbacks
+	clientset           kubernetes.Interface
+	tailers             map[string]*ContainerTailer
+	containerStates     map[string]containerState
+	highWaterMarks      map[string]time.Time
+	pendingOffsetWrites map[string]int
+	offsetStore         OffsetStore
+	namespaces          []string
+	labelSelector       labels.Selector
+	callbacks           Callbacks
+	includeInit         bool
+	includeEphemeral    bool
+	filters             []LogFilter
+	podObservers        []PodObserver
+	podRemovedObservers []PodObserver
+	ctx                 context.Context
+	cancel              context.CancelFunc
+	tailerWG            sync.WaitGroup
 }
 
+// NewController builds a Controller that tails pod.Spec.Containers matching
+// labelSelector across namespaces. A single "" entry (or an empty slice)
+// watches all namespaces. Set includeInit to also tail init containers
+// (closing the stream once each one terminates) and includeEphemeral to also
+// tail ephemeral debug containers. offsetStore may be nil; when set, it
+// backs the controller's in-memory high-water marks so tailing can resume
+// across controller restarts, not just stream reconnects.
 func NewController(
-	clientset *kubernetes.Clientset,
-	namespace string,
+	clientset kubernetes.Interface,
+	namespaces []string,
 	labelSelector labels.Selector,
-	callbacks Callbacks) *Controller {
+	callbacks Callbacks,
+	includeInit bool,
+	includeEphemeral bool,
+	offsetStore OffsetStore) *Controller {
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
 	return &Controller{
-		clientset:     clientset,
-		tailers:       map[string]*ContainerTailer{},
-		namespace:     namespace,
-		labelSelector: labelSelector,
-		callbacks:     callbacks,
+		clientset:           clientset,
+		tailers:             map[string]*ContainerTailer{},
+		containerStates:     map[string]containerState{},
+		highWaterMarks:      map[string]time.Time{},
+		pendingOffsetWrites: map[string]int{},
+		offsetStore:         offsetStore,
+		namespaces:          namespaces,
+		labelSelector:       labelSelector,
+		callbacks:           callbacks,
+		includeInit:         includeInit,
+		includeEphemeral:    includeEphemeral,
 	}
 }
 
-func (ctl *Controller) Run() {
-	podListWatcher := cache.NewListWatchFromClient(
-		ctl.clientset.Core().RESTClient(), "pods", ctl.namespace, fields.Everything())
+// AddPodObserver registers fn to be called with every pod add, update, and
+// initial-list RunContext's informers report, across all configured
+// namespaces, so a consumer (e.g. ktail/discovery.Registry) can reuse the
+// watch instead of establishing its own. Only pods matching this
+// Controller's labelSelector are reported.
+func (ctl *Controller) AddPodObserver(fn PodObserver) {
+	ctl.Lock()
+	defer ctl.Unlock()
+	ctl.podObservers = append(ctl.podObservers, fn)
+}
 
-	obj, err := podListWatcher.List(metav1.ListOptions{})
-	if err != nil {
-		panic(err)
+// AddPodRemovedObserver registers fn to be called with every pod delete
+// RunContext's informers report.
+func (ctl *Controller) AddPodRemovedObserver(fn PodObserver) {
+	ctl.Lock()
+	defer ctl.Unlock()
+	ctl.podRemovedObservers = append(ctl.podRemovedObservers, fn)
+}
+
+func (ctl *Controller) notifyPodObservers(pod *v1.Pod) {
+	ctl.Lock()
+	observers := append([]PodObserver(nil), ctl.podObservers...)
+	ctl.Unlock()
+	for _, observer := range observers {
+		observer(pod)
 	}
-	if podList, ok := obj.(*v1.PodList); ok {
-		for _, pod := range podList.Items {
-			ctl.onInitialAdd(&pod)
+}
+
+func (ctl *Controller) notifyPodRemovedObservers(pod *v1.Pod) {
+	ctl.Lock()
+	observers := append([]PodObserver(nil), ctl.podRemovedObservers...)
+	ctl.Unlock()
+	for _, observer := range observers {
+		observer(pod)
+	}
+}
+
+// recordHighWaterMark updates the in-memory high-water mark for key on every
+// call, but only writes through to the OffsetStore every offsetPersistEvery
+// calls, so a disk-backed store's write latency doesn't become the ceiling on
+// log throughput.
+func (ctl *Controller) recordHighWaterMark(key string, ts time.Time) {
+	ctl.Lock()
+	ctl.highWaterMarks[key] = ts
+	persist := false
+	if ctl.offsetStore != nil {
+		ctl.pendingOffsetWrites[key]++
+		if ctl.pendingOffsetWrites[key] >= offsetPersistEvery {
+			ctl.pendingOffsetWrites[key] = 0
+			persist = true
+		}
+	}
+	ctl.Unlock()
+	if persist {
+		ctl.offsetStore.Set(key, ts)
+	}
+}
+
+// flushOffset writes key's current high-water mark through to the
+// OffsetStore if recordHighWaterMark has a debounced write pending for it,
+// and clears the pending count either way.
+func (ctl *Controller) flushOffset(key string) {
+	ctl.Lock()
+	pending := ctl.pendingOffsetWrites[key] > 0
+	ts := ctl.highWaterMarks[key]
+	delete(ctl.pendingOffsetWrites, key)
+	ctl.Unlock()
+	if pending && ctl.offsetStore != nil {
+		ctl.offsetStore.Set(key, ts)
+	}
+}
+
+// flushPendingOffsets writes through every high-water mark
+// recordHighWaterMark has debounced but not yet persisted, so a clean
+// shutdown doesn't lose up to offsetPersistEvery-1 already-delivered lines'
+// worth of resume state.
+func (ctl *Controller) flushPendingOffsets() {
+	ctl.Lock()
+	keys := make([]string, 0, len(ctl.pendingOffsetWrites))
+	for key, count := range ctl.pendingOffsetWrites {
+		if count > 0 {
+			keys = append(keys, key)
 		}
 	}
+	ctl.Unlock()
+
+	for _, key := range keys {
+		ctl.flushOffset(key)
+	}
+}
+
+// Run watches forever and panics on a list error. It is kept for backward
+// compatibility; new callers should use RunContext, which returns errors and
+// stops cleanly when ctx is canceled.
+func (ctl *Controller) Run() {
+	if err := ctl.RunContext(context.Background()); err != nil {
+		panic(err)
+	}
+}
+
+// RunContext warms the cache for every configured namespace via a shared
+// informer factory per namespace, dispatches pod events until ctx is
+// canceled or Stop is called, then waits for every ContainerTailer to drain
+// before returning.
+func (ctl *Controller) RunContext(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	ctl.Lock()
+	ctl.ctx = ctx
+	ctl.cancel = cancel
+	ctl.Unlock()
 
-	_, informer := cache.NewIndexerInformer(podListWatcher, &v1.Pod{}, 0, cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			if pod, ok := obj.(*v1.Pod); ok {
-				ctl.onAdd(pod)
+	tweakListOptions := func(opts *metav1.ListOptions) {
+		opts.LabelSelector = ctl.labelSelector.String()
+	}
+
+	var syncWG sync.WaitGroup
+	errCh := make(chan error, len(ctl.namespaces))
+
+	for _, namespace := range ctl.namespaces {
+		namespace := namespace
+		factory := informers.NewSharedInformerFactoryWithOptions(ctl.clientset, 0,
+			informers.WithNamespace(namespace),
+			informers.WithTweakListOptions(tweakListOptions))
+
+		podInformer := factory.Core().V1().Pods().Informer()
+		podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				if pod, ok := obj.(*v1.Pod); ok {
+					ctl.onAdd(pod)
+					ctl.notifyPodObservers(pod)
+				}
+			},
+			UpdateFunc: func(old interface{}, new interface{}) {
+				oldPod, ok := old.(*v1.Pod)
+				if !ok {
+					return
+				}
+				newPod, ok := new.(*v1.Pod)
+				if !ok {
+					return
+				}
+				ctl.onUpdate(oldPod, newPod)
+				ctl.notifyPodObservers(newPod)
+			},
+			DeleteFunc: func(obj interface{}) {
+				if pod, ok := obj.(*v1.Pod); ok {
+					ctl.onDelete(pod)
+					ctl.notifyPodRemovedObservers(pod)
+				}
+			},
+		})
+
+		factory.Start(ctx.Done())
+
+		syncWG.Add(1)
+		go func() {
+			defer syncWG.Done()
+			if !cache.WaitForCacheSync(ctx.Done(), podInformer.HasSynced) {
+				return
 			}
-		},
-		UpdateFunc: func(old interface{}, new interface{}) {},
-		DeleteFunc: func(obj interface{}) {
-			if pod, ok := obj.(*v1.Pod); ok {
-				ctl.onDelete(pod)
+			pods, err := factory.Core().V1().Pods().Lister().Pods(namespace).List(ctl.labelSelector)
+			if err != nil {
+				errCh <- fmt.Errorf("ktail: listing pods in namespace %q: %w", namespace, err)
+				return
 			}
-		},
-	}, cache.Indexers{})
+			for _, pod := range pods {
+				ctl.onInitialAdd(pod)
+				ctl.notifyPodObservers(pod)
+			}
+		}()
+	}
 
-	stopCh := make(chan struct{}, 1)
-	go informer.Run(stopCh)
-	<-stopCh
+	syncWG.Wait()
+	close(errCh)
+	for err := range errCh {
+		if err != nil {
+			cancel()
+			ctl.tailerWG.Wait()
+			ctl.flushPendingOffsets()
+			return err
+		}
+	}
+
+	<-ctx.Done()
+	ctl.tailerWG.Wait()
+	ctl.flushPendingOffsets()
+	if err := ctx.Err(); err != context.Canceled {
+		return err
+	}
+	return nil
+}
+
+// Stop cancels the context RunContext is watching and blocks until every
+// ContainerTailer it started has drained.
+func (ctl *Controller) Stop() {
+	ctl.Lock()
+	cancel := ctl.cancel
+	ctl.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	ctl.tailerWG.Wait()
+	ctl.flushPendingOffsets()
 }
 
 func (ctl *Controller) onInitialAdd(pod *v1.Pod) {
 	if !ctl.labelSelector.Matches(labels.Set(pod.Labels)) {
 		return
 	}
-	for _, container := range pod.Spec.Containers {
-		ctl.addContainer(pod, &container, true)
-	}
+	ctl.forEachContainer(pod, func(container *v1.Container, isInit, isEphemeral bool) {
+		ctl.addContainer(pod, container, true, isInit, isEphemeral)
+	})
 }
 
 func (ctl *Controller) onAdd(pod *v1.Pod) {
 	if !ctl.labelSelector.Matches(labels.Set(pod.Labels)) {
 		return
 	}
-	for _, container := range pod.Spec.Containers {
-		ctl.addContainer(pod, &container, false)
+	ctl.forEachContainer(pod, func(container *v1.Container, isInit, isEphemeral bool) {
+		ctl.addContainer(pod, container, false, isInit, isEphemeral)
+	})
+}
+
+func (ctl *Controller) onUpdate(oldPod, newPod *v1.Pod) {
+	if !ctl.labelSelector.Matches(labels.Set(newPod.Labels)) {
+		return
 	}
+
+	ctl.forEachContainer(newPod, func(container *v1.Container, isInit, isEphemeral bool) {
+		key := buildKey(newPod, container)
+
+		ctl.Lock()
+		_, tracked := ctl.tailers[key]
+		ctl.Unlock()
+
+		if !tracked {
+			// A container this controller doesn't yet know about: either
+			// newly appended to spec.Containers, or a freshly attached
+			// ephemeral debug container.
+			ctl.addContainer(newPod, container, false, isInit, isEphemeral)
+			return
+		}
+
+		ctl.restartIfNeeded(newPod, container)
+	})
 }
 
-func (ctl *Controller) onDelete(pod *v1.Pod) {
+// forEachContainer invokes fn for every container this controller is
+// configured to tail: always spec.Containers, plus spec.InitContainers and
+// spec.EphemeralContainers when the corresponding include flag is set.
+func (ctl *Controller) forEachContainer(pod *v1.Pod, fn func(container *v1.Container, isInit, isEphemeral bool)) {
+	if ctl.includeInit {
+		for _, container := range pod.Spec.InitContainers {
+			container := container
+			fn(&container, true, false)
+		}
+	}
 	for _, container := range pod.Spec.Containers {
-		ctl.deleteContainer(pod, &container)
+		container := container
+		fn(&container, false, false)
+	}
+	if ctl.includeEphemeral {
+		for _, ephemeral := range pod.Spec.EphemeralContainers {
+			container := v1.Container(ephemeral.EphemeralContainerCommon)
+			fn(&container, false, true)
+		}
 	}
 }
 
-func (ctl *Controller) addContainer(pod *v1.Pod, container *v1.Container, discoveryPhase bool) {
+// restartIfNeeded compares the live containerID/restartCount for container
+// against what was running the last time we started a tailer for it. If they
+// differ -- a crash, an image roll, or any other replacement of the
+// underlying container -- the stale tailer is stopped and a fresh one is
+// bound to the new instance. A container that hasn't actually started yet
+// (no containerID, or not yet Running/Terminated) is ignored rather than
+// compared, so the Waiting-to-Running transition every container goes
+// through on creation is never mistaken for a restart.
+func (ctl *Controller) restartIfNeeded(pod *v1.Pod, container *v1.Container) {
+	status, ok := containerStatus(pod, container.Name)
+	if !ok || !containerStarted(status) {
+		return
+	}
+
+	key := buildKey(pod, container)
+	current := containerState{containerID: status.ContainerID, restartCount: status.RestartCount}
+
+	ctl.Lock()
+	previous, known := ctl.containerStates[key]
+	if !known {
+		// First time we've observed this container actually running:
+		// record the baseline instead of treating it as a restart.
+		ctl.containerStates[key] = current
+	}
+	ctl.Unlock()
+
+	if !known || previous == current {
+		return
+	}
+
+	isInit, isEphemeral := ctl.containerPhase(key)
+
+	ctl.deleteContainer(pod, container)
+	ctl.addContainer(pod, container, false, isInit, isEphemeral)
+
+	if ctl.callbacks.OnRestart != nil {
+		ctl.callbacks.OnRestart(pod, container, previous.containerID, current.containerID, current.restartCount)
+	}
+}
+
+// containerStarted reports whether status reflects a container that has
+// actually been created by the runtime -- a non-empty ContainerID that has
+// reached Running or Terminated -- as opposed to one still Waiting (e.g.
+// ContainerCreating), which carries no ContainerID yet.
+func containerStarted(status v1.ContainerStatus) bool {
+	return status.ContainerID != "" && (status.State.Running != nil || status.State.Terminated != nil)
+}
+
+// containerPhase reports the isInit/isEphemeral bits the tailer for key was
+// started with, so a restart can be re-created with the same phase.
+func (ctl *Controller) containerPhase(key string) (isInit, isEphemeral bool) {
+	ctl.Lock()
+	defer ctl.Unlock()
+	if tailer, ok := ctl.tailers[key]; ok {
+		return tailer.isInit, tailer.isEphemeral
+	}
+	return false, false
+}
+
+func (ctl *Controller) onDelete(pod *v1.Pod) {
+	ctl.forEachContainer(pod, func(container *v1.Container, isInit, isEphemeral bool) {
+		ctl.deleteContainer(pod, container)
+	})
+}
+
+func (ctl *Controller) addContainer(pod *v1.Pod, container *v1.Container, discoveryPhase, isInit, isEphemeral bool) {
 	ctl.Lock()
 	defer ctl.Unlock()
 
@@ -114,34 +450,102 @@ func (ctl *Controller) addContainer(pod *v1.Pod, container *v1.Container, discov
 		return
 	}
 
-	if !ctl.callbacks.OnEnter(pod, container) {
+	if !ctl.callbacks.OnEnter(pod, container, isInit, isEphemeral) {
 		return
 	}
 
 	targetPod, targetContainer := *pod, *container // Copy to avoid mutation
 
-	tailer := NewContainerTailer(ctl.clientset, targetPod, targetContainer, ctl.callbacks.OnEvent,
-		!discoveryPhase)
+	ctx := ctl.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	onReconnect := func(attempt int, err error) {
+		if ctl.callbacks.OnReconnect != nil {
+			ctl.callbacks.OnReconnect(&targetPod, &targetContainer, attempt, err)
+		}
+	}
+	onProgress := func(ts time.Time) {
+		ctl.recordHighWaterMark(key, ts)
+	}
+	onLine := func(pod *v1.Pod, container *v1.Container, line []byte) {
+		event, ok := ctl.runFilters(LogEvent{Pod: pod, Container: container, Raw: line})
+		if !ok {
+			return
+		}
+		ctl.callbacks.OnEvent(event)
+	}
+
+	// ctl is already locked here, so the high-water mark is read directly
+	// off the map.
+	sinceTime := ctl.highWaterMarks[key]
+	if sinceTime.IsZero() && ctl.offsetStore != nil {
+		if ts, ok, err := ctl.offsetStore.Get(key); err == nil && ok {
+			sinceTime = ts
+		}
+	}
+
+	tailer := NewContainerTailer(ctx, ctl.clientset, targetPod, targetContainer, onLine,
+		!discoveryPhase, isInit, isEphemeral, sinceTime, onReconnect, onProgress)
+	ctl.tailerWG.Add(1)
 	go func() {
+		defer ctl.tailerWG.Done()
 		if err := tailer.Run(); err != nil {
 			ctl.callbacks.OnError(&targetPod, &targetContainer, err)
 		}
 	}()
 	ctl.tailers[key] = tailer
+
+	if status, ok := containerStatus(pod, container.Name); ok && containerStarted(status) {
+		ctl.containerStates[key] = containerState{containerID: status.ContainerID, restartCount: status.RestartCount}
+	}
 }
 
 func (ctl *Controller) deleteContainer(pod *v1.Pod, container *v1.Container) {
-	ctl.Lock()
-	defer ctl.Unlock()
-
 	key := buildKey(pod, container)
-	if tailer, ok := ctl.tailers[key]; ok {
+
+	ctl.Lock()
+	tailer, ok := ctl.tailers[key]
+	if ok {
 		delete(ctl.tailers, key)
-		tailer.Stop()
-		ctl.callbacks.OnExit(pod, container)
+		delete(ctl.containerStates, key)
+	}
+	ctl.Unlock()
+	if !ok {
+		return
 	}
+
+	tailer.Stop()
+	ctl.callbacks.OnExit(pod, container)
+	// Flush whatever debounced high-water mark recordHighWaterMark hasn't
+	// persisted yet, now that no more lines are coming for this container:
+	// otherwise the next resume picks up a stale OffsetStore timestamp and
+	// redelivers lines that were already processed.
+	ctl.flushOffset(key)
 }
 
 func buildKey(pod *v1.Pod, container *v1.Container) string {
 	return fmt.Sprintf("%s/%s/%s", pod.Namespace, pod.Name, container.Name)
 }
+
+// containerStatus finds the status entry for the named container, searching
+// regular, init, and ephemeral containers in turn.
+func containerStatus(pod *v1.Pod, name string) (v1.ContainerStatus, bool) {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == name {
+			return status, true
+		}
+	}
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.Name == name {
+			return status, true
+		}
+	}
+	for _, status := range pod.Status.EphemeralContainerStatuses {
+		if status.Name == name {
+			return status, true
+		}
+	}
+	return v1.ContainerStatus{}, false
+}
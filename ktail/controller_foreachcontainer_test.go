@@ -0,0 +1,72 @@
+package ktail
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+)
+
+func TestForEachContainerDispatchesInitAndEphemeral(t *testing.T) {
+	pod := &v1.Pod{
+		Spec: v1.PodSpec{
+			InitContainers: []v1.Container{{Name: "init"}},
+			Containers:     []v1.Container{{Name: "app"}},
+			EphemeralContainers: []v1.EphemeralContainer{{
+				EphemeralContainerCommon: v1.EphemeralContainerCommon{Name: "debug"},
+			}},
+		},
+	}
+
+	type seen struct {
+		name                string
+		isInit, isEphemeral bool
+	}
+
+	cases := []struct {
+		name             string
+		includeInit      bool
+		includeEphemeral bool
+		want             []seen
+	}{
+		{
+			name: "only regular containers by default",
+			want: []seen{{"app", false, false}},
+		},
+		{
+			name:        "init containers included when requested",
+			includeInit: true,
+			want:        []seen{{"init", true, false}, {"app", false, false}},
+		},
+		{
+			name:             "ephemeral containers included when requested",
+			includeEphemeral: true,
+			want:             []seen{{"app", false, false}, {"debug", false, true}},
+		},
+		{
+			name:             "both included together",
+			includeInit:      true,
+			includeEphemeral: true,
+			want:             []seen{{"init", true, false}, {"app", false, false}, {"debug", false, true}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctl := &Controller{includeInit: tc.includeInit, includeEphemeral: tc.includeEphemeral}
+
+			var got []seen
+			ctl.forEachContainer(pod, func(container *v1.Container, isInit, isEphemeral bool) {
+				got = append(got, seen{container.Name, isInit, isEphemeral})
+			})
+
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("entry %d: got %+v, want %+v", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package ktail
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestContainerStartedRequiresContainerIDAndState(t *testing.T) {
+	cases := []struct {
+		name   string
+		status v1.ContainerStatus
+		want   bool
+	}{
+		{
+			name:   "waiting with no containerID",
+			status: v1.ContainerStatus{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{}}},
+			want:   false,
+		},
+		{
+			name:   "running but containerID not yet populated",
+			status: v1.ContainerStatus{State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}},
+			want:   false,
+		},
+		{
+			name: "running with containerID",
+			status: v1.ContainerStatus{
+				ContainerID: "docker://abc",
+				State:       v1.ContainerState{Running: &v1.ContainerStateRunning{}},
+			},
+			want: true,
+		},
+		{
+			name: "terminated with containerID",
+			status: v1.ContainerStatus{
+				ContainerID: "docker://abc",
+				State:       v1.ContainerState{Terminated: &v1.ContainerStateTerminated{}},
+			},
+			want: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := containerStarted(tc.status); got != tc.want {
+				t.Errorf("containerStarted(%+v) = %v, want %v", tc.status, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRestartIfNeededIgnoresInitialStart(t *testing.T) {
+	var restarts int
+	ctl := newTestController(nil)
+	ctl.callbacks = Callbacks{
+		// OnEnter returns false so a detected restart doesn't go on to spin
+		// up a real ContainerTailer goroutine against a nil clientset; this
+		// test only cares about the restart/baseline detection itself.
+		OnEnter: func(pod *v1.Pod, container *v1.Container, isInit, isEphemeral bool) bool { return false },
+		OnExit:  func(pod *v1.Pod, container *v1.Container) {},
+		OnRestart: func(pod *v1.Pod, container *v1.Container, oldID, newID string, restartCount int32) {
+			restarts++
+		},
+	}
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "pod"},
+		Status: v1.PodStatus{
+			ContainerStatuses: []v1.ContainerStatus{{
+				Name:        "app",
+				ContainerID: "",
+				State:       v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+			}},
+		},
+	}
+	container := &v1.Container{Name: "app"}
+
+	// Still Waiting: must not be recorded as a baseline or treated as a
+	// restart.
+	ctl.restartIfNeeded(pod, container)
+	if restarts != 0 {
+		t.Fatalf("restarts = %d while container is still Waiting, want 0", restarts)
+	}
+	if _, known := ctl.containerStates[buildKey(pod, container)]; known {
+		t.Fatal("containerStates seeded from a Waiting status with no ContainerID")
+	}
+
+	// First real start: establishes the baseline, still not a restart.
+	pod.Status.ContainerStatuses[0].ContainerID = "docker://abc"
+	pod.Status.ContainerStatuses[0].State = v1.ContainerState{Running: &v1.ContainerStateRunning{}}
+	ctl.restartIfNeeded(pod, container)
+	if restarts != 0 {
+		t.Fatalf("restarts = %d on the container's first observed start, want 0", restarts)
+	}
+
+	// An actual restart: new containerID, now compared against a known
+	// baseline.
+	pod.Status.ContainerStatuses[0].ContainerID = "docker://def"
+	pod.Status.ContainerStatuses[0].RestartCount = 1
+	ctl.restartIfNeeded(pod, container)
+	if restarts != 1 {
+		t.Fatalf("restarts = %d after a real restart, want 1", restarts)
+	}
+}
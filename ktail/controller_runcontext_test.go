@@ -0,0 +1,122 @@
+package ktail
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func runningPod(namespace, name string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "app"}}},
+		Status: v1.PodStatus{
+			ContainerStatuses: []v1.ContainerStatus{{
+				Name:        "app",
+				ContainerID: "docker://abc",
+				State:       v1.ContainerState{Running: &v1.ContainerStateRunning{}},
+			}},
+		},
+	}
+}
+
+func TestRunContextDiscoversPodsAcrossNamespaces(t *testing.T) {
+	clientset := fake.NewSimpleClientset(runningPod("ns-a", "pod-a"), runningPod("ns-b", "pod-b"))
+
+	var mu sync.Mutex
+	var entered []string
+
+	ctl := NewController(clientset, []string{"ns-a", "ns-b"}, labels.Everything(), Callbacks{
+		OnEnter: func(pod *v1.Pod, container *v1.Container, isInit, isEphemeral bool) bool {
+			mu.Lock()
+			entered = append(entered, pod.Namespace+"/"+pod.Name)
+			mu.Unlock()
+			return false // don't spin up a real tailer against the fake clientset's log stream
+		},
+		OnExit: func(pod *v1.Pod, container *v1.Container) {},
+	}, false, false, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- ctl.RunContext(ctx) }()
+
+	deadline := time.After(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(entered)
+		mu.Unlock()
+		if n >= 2 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("got entered=%v after timeout, want both pods discovered", entered)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	mu.Lock()
+	got := append([]string(nil), entered...)
+	mu.Unlock()
+	want := map[string]bool{"ns-a/pod-a": true, "ns-b/pod-b": true}
+	for _, name := range got {
+		if !want[name] {
+			t.Errorf("unexpected pod entered: %s", name)
+		}
+		delete(want, name)
+	}
+	if len(want) != 0 {
+		t.Errorf("pods never entered: %v", want)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("RunContext returned %v after cancel, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("RunContext did not return after ctx was canceled")
+	}
+}
+
+// TestRunContextReturnsNonCancelContextError checks that RunContext surfaces
+// a non-cancellation context error (e.g. a deadline, as opposed to an
+// explicit Stop/cancel) as its return value instead of treating it the same
+// as a clean shutdown -- here forced by a namespace whose informer can never
+// complete its initial sync because every list attempt errors.
+func TestRunContextReturnsNonCancelContextError(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("list", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("boom")
+	})
+
+	ctl := NewController(clientset, []string{"ns-a"}, labels.Everything(), Callbacks{
+		OnEnter: func(pod *v1.Pod, container *v1.Container, isInit, isEphemeral bool) bool { return false },
+		OnExit:  func(pod *v1.Pod, container *v1.Container) {},
+	}, false, false, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- ctl.RunContext(ctx) }()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("RunContext returned %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("RunContext did not return after its context's deadline passed")
+	}
+}
@@ -0,0 +1,101 @@
+package ktail
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeOffsetStore struct {
+	values map[string]time.Time
+}
+
+func newFakeOffsetStore() *fakeOffsetStore {
+	return &fakeOffsetStore{values: map[string]time.Time{}}
+}
+
+func (f *fakeOffsetStore) Get(key string) (time.Time, bool, error) {
+	ts, ok := f.values[key]
+	return ts, ok, nil
+}
+
+func (f *fakeOffsetStore) Set(key string, ts time.Time) error {
+	f.values[key] = ts
+	return nil
+}
+
+func newTestController(store OffsetStore) *Controller {
+	return &Controller{
+		tailers:             map[string]*ContainerTailer{},
+		containerStates:     map[string]containerState{},
+		highWaterMarks:      map[string]time.Time{},
+		pendingOffsetWrites: map[string]int{},
+		offsetStore:         store,
+	}
+}
+
+func TestRecordHighWaterMarkDebouncesOffsetWrites(t *testing.T) {
+	store := newFakeOffsetStore()
+	ctl := newTestController(store)
+
+	base := time.Unix(1700000000, 0)
+	for i := 0; i < offsetPersistEvery-1; i++ {
+		ctl.recordHighWaterMark("key", base.Add(time.Duration(i)*time.Second))
+	}
+	if _, ok, _ := store.Get("key"); ok {
+		t.Fatal("offsetStore.Set was called before offsetPersistEvery lines were delivered")
+	}
+
+	last := base.Add(offsetPersistEvery * time.Second)
+	ctl.recordHighWaterMark("key", last)
+	ts, ok, _ := store.Get("key")
+	if !ok || !ts.Equal(last) {
+		t.Fatalf("got (%v, %v), want (%v, true)", ts, ok, last)
+	}
+}
+
+func TestFlushOffsetPersistsPendingWrite(t *testing.T) {
+	store := newFakeOffsetStore()
+	ctl := newTestController(store)
+
+	ts := time.Unix(1700000000, 0)
+	ctl.recordHighWaterMark("key", ts) // one delivered line, below the debounce threshold
+	if _, ok, _ := store.Get("key"); ok {
+		t.Fatal("offsetStore.Set was called before the debounce threshold")
+	}
+
+	ctl.flushOffset("key")
+	got, ok, _ := store.Get("key")
+	if !ok || !got.Equal(ts) {
+		t.Fatalf("got (%v, %v), want (%v, true)", got, ok, ts)
+	}
+	if ctl.pendingOffsetWrites["key"] != 0 {
+		t.Errorf("pendingOffsetWrites[%q] = %d, want 0 after flush", "key", ctl.pendingOffsetWrites["key"])
+	}
+}
+
+func TestFlushOffsetNoopWithoutPendingWrite(t *testing.T) {
+	store := newFakeOffsetStore()
+	ctl := newTestController(store)
+
+	ctl.flushOffset("key")
+	if _, ok, _ := store.Get("key"); ok {
+		t.Fatal("flushOffset persisted a key with nothing pending")
+	}
+}
+
+func TestFlushPendingOffsetsFlushesEveryKey(t *testing.T) {
+	store := newFakeOffsetStore()
+	ctl := newTestController(store)
+
+	ctl.recordHighWaterMark("a", time.Unix(1, 0))
+	ctl.recordHighWaterMark("b", time.Unix(2, 0))
+
+	ctl.flushPendingOffsets()
+
+	if _, ok, _ := store.Get("a"); !ok {
+		t.Error(`"a" was not flushed`)
+	}
+	if _, ok, _ := store.Get("b"); !ok {
+		t.Error(`"b" was not flushed`)
+	}
+}
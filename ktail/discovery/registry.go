@@ -0,0 +1,253 @@
+// Package discovery builds a live registry of models.ServicePort values from
+// running pods, so Metaparticle's AST can be materialized from a cluster
+// instead of only from static specs.
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/smothiki/metaparticle-ast/ktail"
+	"github.com/smothiki/metaparticle-ast/models"
+)
+
+const (
+	// AnnotationApp names the application a pod belongs to.
+	AnnotationApp = "metaparticle.io/service-app"
+	// AnnotationVersion names the version of the application a pod runs.
+	AnnotationVersion = "metaparticle.io/service-version"
+	// AnnotationPorts holds a JSON array of {"number":N,"protocol":"TCP"}
+	// objects describing which of the pod's container ports are services.
+	AnnotationPorts = "metaparticle.io/ports"
+)
+
+type portAnnotation struct {
+	Number   int32  `json:"number"`
+	Protocol string `json:"protocol"`
+}
+
+type appVersion struct {
+	app     string
+	version string
+}
+
+// Registry maps metaparticle.io-annotated pods to the models.ServicePort
+// values implied by their annotations and container ports, and notifies
+// watchers as pods serving an app become Ready or go away.
+type Registry struct {
+	sync.Mutex
+	byAppVersion map[string]map[string][]*models.ServicePort
+	podKeyToApp  map[string]appVersion
+	watchers     map[string][]chan []*models.ServicePort
+}
+
+// NewRegistry returns an empty Registry. Call RunContext to start populating
+// it from a running cluster.
+func NewRegistry() *Registry {
+	return &Registry{
+		byAppVersion: map[string]map[string][]*models.ServicePort{},
+		podKeyToApp:  map[string]appVersion{},
+		watchers:     map[string][]chan []*models.ServicePort{},
+	}
+}
+
+// RunContext rides ctl's existing pod informers instead of opening a second
+// watch against the same apiserver: it registers the registry's handlers as
+// pod observers on ctl and blocks until ctx is canceled. The observers stay
+// registered on ctl after it returns, so the registry keeps tracking ctl's
+// pod events for as long as ctl runs.
+// Call it after ctl.AddPodObserver-compatible setup but before (or
+// concurrently with) ctl.RunContext, since observers only see events that
+// arrive after they're registered.
+func (r *Registry) RunContext(ctx context.Context, ctl *ktail.Controller) error {
+	ctl.AddPodObserver(r.handlePod)
+	ctl.AddPodRemovedObserver(r.handlePodRemoved)
+
+	<-ctx.Done()
+	return nil
+}
+
+// Watch returns a channel that receives the current set of ServicePorts for
+// app -- across every version currently Ready -- every time membership
+// changes, starting with the set as of the call. The channel is buffered by
+// one and never closed; a caller that no longer cares should simply stop
+// reading from it.
+func (r *Registry) Watch(app string) <-chan []*models.ServicePort {
+	ch := make(chan []*models.ServicePort, 1)
+
+	r.Lock()
+	r.watchers[app] = append(r.watchers[app], ch)
+	current := r.snapshotLocked(app)
+	r.Unlock()
+
+	// ch was just registered, so notify may already have delivered a
+	// fresher snapshot into it before this initial send runs. Send the
+	// same way notify does: non-blocking, replacing whatever's already
+	// buffered rather than risking a blocking send nothing will drain.
+	select {
+	case ch <- current:
+	default:
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- current
+	}
+	return ch
+}
+
+// Lookup returns the ServicePorts currently known for app/version, or nil if
+// none are Ready.
+func (r *Registry) Lookup(app, version string) []*models.ServicePort {
+	r.Lock()
+	defer r.Unlock()
+	return r.byAppVersion[app][version]
+}
+
+func (r *Registry) handlePod(pod *v1.Pod) {
+	app := pod.Annotations[AnnotationApp]
+	if app == "" {
+		return
+	}
+
+	if !isPodReady(pod) {
+		r.handlePodRemoved(pod)
+		return
+	}
+
+	ports := servicePortsFromAnnotations(pod)
+	if len(ports) == 0 {
+		return
+	}
+	version := pod.Annotations[AnnotationVersion]
+
+	r.Lock()
+	versions, ok := r.byAppVersion[app]
+	if !ok {
+		versions = map[string][]*models.ServicePort{}
+		r.byAppVersion[app] = versions
+	}
+	versions[version] = ports
+	r.podKeyToApp[podKey(pod)] = appVersion{app: app, version: version}
+	r.Unlock()
+
+	r.notify(app)
+}
+
+func (r *Registry) handlePodRemoved(pod *v1.Pod) {
+	key := podKey(pod)
+
+	r.Lock()
+	av, tracked := r.podKeyToApp[key]
+	if tracked {
+		delete(r.podKeyToApp, key)
+		stillServed := false
+		for _, other := range r.podKeyToApp {
+			if other == av {
+				stillServed = true
+				break
+			}
+		}
+		if versions, ok := r.byAppVersion[av.app]; ok && !stillServed {
+			delete(versions, av.version)
+			if len(versions) == 0 {
+				delete(r.byAppVersion, av.app)
+			}
+		}
+	}
+	r.Unlock()
+
+	if tracked {
+		r.notify(av.app)
+	}
+}
+
+// snapshotLocked returns every ServicePort known for app, across all
+// versions. Callers must hold the lock.
+func (r *Registry) snapshotLocked(app string) []*models.ServicePort {
+	var all []*models.ServicePort
+	for _, ports := range r.byAppVersion[app] {
+		all = append(all, ports...)
+	}
+	return all
+}
+
+func (r *Registry) notify(app string) {
+	r.Lock()
+	snapshot := r.snapshotLocked(app)
+	watchers := append([]chan []*models.ServicePort(nil), r.watchers[app]...)
+	r.Unlock()
+
+	for _, ch := range watchers {
+		select {
+		case ch <- snapshot:
+		default:
+			// A Watch channel always carries the latest snapshot rather
+			// than a queue of every intermediate one: drop the stale value
+			// the watcher hasn't read yet and replace it with this one.
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- snapshot
+		}
+	}
+}
+
+func podKey(pod *v1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+func isPodReady(pod *v1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == v1.PodReady {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// servicePortsFromAnnotations parses AnnotationPorts and keeps only the
+// entries that match one of the pod's container ports, filling in the
+// protocol from the container port when the annotation omits it.
+func servicePortsFromAnnotations(pod *v1.Pod) []*models.ServicePort {
+	raw := pod.Annotations[AnnotationPorts]
+	if raw == "" {
+		return nil
+	}
+
+	var declared []portAnnotation
+	if err := json.Unmarshal([]byte(raw), &declared); err != nil {
+		return nil
+	}
+
+	var ports []*models.ServicePort
+	for _, d := range declared {
+		protocol := d.Protocol
+		matched := false
+		for _, container := range pod.Spec.Containers {
+			for _, containerPort := range container.Ports {
+				if containerPort.ContainerPort != d.Number {
+					continue
+				}
+				matched = true
+				if protocol == "" {
+					protocol = string(containerPort.Protocol)
+				}
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		number := d.Number
+		ports = append(ports, &models.ServicePort{
+			Number:   &number,
+			Protocol: protocol,
+		})
+	}
+	return ports
+}
@@ -0,0 +1,153 @@
+package discovery
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func readyPod(namespace, name, app, version, portsAnnotation string, containerPorts ...v1.ContainerPort) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Annotations: map[string]string{
+				AnnotationApp:     app,
+				AnnotationVersion: version,
+				AnnotationPorts:   portsAnnotation,
+			},
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Ports: containerPorts}},
+		},
+		Status: v1.PodStatus{
+			Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}},
+		},
+	}
+}
+
+func TestServicePortsFromAnnotationsMatchesContainerPorts(t *testing.T) {
+	pod := readyPod("ns", "p", "app", "v1",
+		`[{"number":8080,"protocol":"TCP"},{"number":9090},{"number":7070}]`,
+		v1.ContainerPort{ContainerPort: 8080, Protocol: v1.ProtocolTCP},
+		v1.ContainerPort{ContainerPort: 9090, Protocol: v1.ProtocolUDP},
+	)
+
+	ports := servicePortsFromAnnotations(pod)
+	if len(ports) != 2 {
+		t.Fatalf("got %d ports, want 2 (port 7070 has no matching container port): %+v", len(ports), ports)
+	}
+
+	byNumber := map[int32]string{}
+	for _, p := range ports {
+		byNumber[*p.Number] = p.Protocol
+	}
+	if byNumber[8080] != "TCP" {
+		t.Errorf("port 8080 protocol = %q, want explicit annotation value TCP", byNumber[8080])
+	}
+	if byNumber[9090] != "UDP" {
+		t.Errorf("port 9090 protocol = %q, want UDP filled in from the container port", byNumber[9090])
+	}
+}
+
+func TestServicePortsFromAnnotationsEmptyOrInvalid(t *testing.T) {
+	cases := []struct {
+		name string
+		raw  string
+	}{
+		{"no annotation", ""},
+		{"invalid JSON", "not-json"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := readyPod("ns", "p", "app", "v1", tc.raw)
+			if got := servicePortsFromAnnotations(pod); got != nil {
+				t.Errorf("got %v, want nil", got)
+			}
+		})
+	}
+}
+
+func TestHandlePodAddsReadyPodAndNotifiesWatchers(t *testing.T) {
+	r := NewRegistry()
+	ch := r.Watch("app")
+	<-ch // drain the initial (empty) snapshot
+
+	pod := readyPod("ns", "p", "app", "v1", `[{"number":8080,"protocol":"TCP"}]`,
+		v1.ContainerPort{ContainerPort: 8080, Protocol: v1.ProtocolTCP})
+	r.handlePod(pod)
+
+	select {
+	case got := <-ch:
+		if len(got) != 1 || *got[0].Number != 8080 {
+			t.Fatalf("got %v, want the pod's single service port", got)
+		}
+	default:
+		t.Fatal("handlePod did not notify the app's watcher")
+	}
+
+	if got := r.Lookup("app", "v1"); len(got) != 1 {
+		t.Fatalf("Lookup(app, v1) = %v, want the single service port", got)
+	}
+}
+
+func TestHandlePodWithoutAppAnnotationIsIgnored(t *testing.T) {
+	r := NewRegistry()
+	pod := readyPod("ns", "p", "", "v1", "")
+	r.handlePod(pod)
+
+	if got := r.snapshotLocked(""); got != nil {
+		t.Errorf("got %v, want nil: pod lacking the app annotation must not be tracked", got)
+	}
+}
+
+func TestHandlePodNotReadyIsTreatedAsRemoved(t *testing.T) {
+	r := NewRegistry()
+	pod := readyPod("ns", "p", "app", "v1", `[{"number":8080,"protocol":"TCP"}]`,
+		v1.ContainerPort{ContainerPort: 8080, Protocol: v1.ProtocolTCP})
+	r.handlePod(pod)
+	if got := r.Lookup("app", "v1"); len(got) != 1 {
+		t.Fatalf("Lookup(app, v1) = %v, want the pod to be tracked once Ready", got)
+	}
+
+	pod.Status.Conditions[0].Status = v1.ConditionFalse
+	r.handlePod(pod)
+	if got := r.Lookup("app", "v1"); got != nil {
+		t.Fatalf("Lookup(app, v1) = %v, want nil once the pod stops being Ready", got)
+	}
+}
+
+func TestHandlePodRemovedUntracksPodAndNotifies(t *testing.T) {
+	r := NewRegistry()
+	pod := readyPod("ns", "p", "app", "v1", `[{"number":8080,"protocol":"TCP"}]`,
+		v1.ContainerPort{ContainerPort: 8080, Protocol: v1.ProtocolTCP})
+	r.handlePod(pod)
+
+	ch := r.Watch("app")
+	<-ch // drain the just-added pod's snapshot
+
+	r.handlePodRemoved(pod)
+
+	select {
+	case got := <-ch:
+		if got != nil {
+			t.Fatalf("got %v, want nil once the only pod for app/v1 is removed", got)
+		}
+	default:
+		t.Fatal("handlePodRemoved did not notify the app's watcher")
+	}
+	if got := r.Lookup("app", "v1"); got != nil {
+		t.Fatalf("Lookup(app, v1) = %v, want nil after removal", got)
+	}
+}
+
+func TestHandlePodRemovedUntrackedPodIsNoop(t *testing.T) {
+	r := NewRegistry()
+	pod := readyPod("ns", "p", "app", "v1", "")
+	// Never added via handlePod, so it's not in podKeyToApp.
+	r.handlePodRemoved(pod)
+	if got := r.Lookup("app", "v1"); got != nil {
+		t.Fatalf("got %v, want nil", got)
+	}
+}
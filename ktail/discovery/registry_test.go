@@ -0,0 +1,76 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/smothiki/metaparticle-ast/models"
+)
+
+func numberPort(number int32, protocol string) *models.ServicePort {
+	n := number
+	return &models.ServicePort{Number: &n, Protocol: protocol}
+}
+
+func TestSnapshotLockedMergesVersions(t *testing.T) {
+	r := NewRegistry()
+	r.byAppVersion["app"] = map[string][]*models.ServicePort{
+		"v1": {numberPort(8080, "TCP")},
+		"v2": {numberPort(9090, "TCP")},
+	}
+
+	got := r.snapshotLocked("app")
+	if len(got) != 2 {
+		t.Fatalf("snapshotLocked returned %d ports, want 2", len(got))
+	}
+}
+
+func TestSnapshotLockedUnknownApp(t *testing.T) {
+	r := NewRegistry()
+	if got := r.snapshotLocked("missing"); got != nil {
+		t.Errorf("snapshotLocked(%q) = %v, want nil", "missing", got)
+	}
+}
+
+func TestWatchDeliversCurrentSnapshotFirst(t *testing.T) {
+	r := NewRegistry()
+	r.byAppVersion["app"] = map[string][]*models.ServicePort{
+		"v1": {numberPort(8080, "TCP")},
+	}
+
+	ch := r.Watch("app")
+	select {
+	case got := <-ch:
+		if len(got) != 1 {
+			t.Fatalf("got %d ports, want 1", len(got))
+		}
+	default:
+		t.Fatal("Watch did not deliver the current snapshot")
+	}
+}
+
+func TestNotifyReplacesUndrainedSnapshot(t *testing.T) {
+	r := NewRegistry()
+	ch := make(chan []*models.ServicePort, 1)
+	r.watchers["app"] = []chan []*models.ServicePort{ch}
+
+	r.byAppVersion["app"] = map[string][]*models.ServicePort{
+		"v1": {numberPort(8080, "TCP")},
+	}
+	r.notify("app")
+
+	r.byAppVersion["app"]["v1"] = []*models.ServicePort{numberPort(9090, "TCP")}
+	r.notify("app")
+
+	// notify must never block even though the channel's only slot was
+	// already full from the first notify; the second call should have
+	// replaced it rather than piling up.
+	got := <-ch
+	if len(got) != 1 || *got[0].Number != 9090 {
+		t.Fatalf("got %v, want the latest snapshot (port 9090)", got)
+	}
+	select {
+	case extra := <-ch:
+		t.Fatalf("channel had a second queued snapshot: %v", extra)
+	default:
+	}
+}
@@ -0,0 +1,144 @@
+package ktail
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"k8s.io/api/core/v1"
+)
+
+// LogEvent is a single log line plus whatever a Controller's filter chain
+// has been able to promote out of it.
+type LogEvent struct {
+	Pod       *v1.Pod
+	Container *v1.Container
+	Raw       []byte
+
+	// Populated by the built-in JSON detector filter when Raw parses as a
+	// JSON object carrying the corresponding field.
+	Level     string
+	Timestamp time.Time
+	Message   string
+	TraceID   string
+
+	// Populated by the built-in pod metadata filter.
+	Node   string
+	PodIP  string
+	PodIPs []string
+	Labels map[string]string
+}
+
+// LogEventFunc is invoked once per log line that survives a Controller's
+// filter chain.
+type LogEventFunc func(event LogEvent)
+
+// LogFilter inspects or enriches a LogEvent. Returning false drops the line
+// before it reaches OnEvent and before any later filter in the chain runs.
+type LogFilter func(event LogEvent) (LogEvent, bool)
+
+// AddLogFilter appends filter to the end of the controller's filter chain.
+// Filters run in the order they were added, each seeing the LogEvent the
+// previous one produced.
+func (ctl *Controller) AddLogFilter(filter LogFilter) {
+	ctl.Lock()
+	defer ctl.Unlock()
+	ctl.filters = append(ctl.filters, filter)
+}
+
+// runFilters threads event through the controller's filter chain, returning
+// ok=false if any filter dropped the line.
+func (ctl *Controller) runFilters(event LogEvent) (LogEvent, bool) {
+	ctl.Lock()
+	filters := append([]LogFilter(nil), ctl.filters...)
+	ctl.Unlock()
+
+	for _, filter := range filters {
+		var ok bool
+		event, ok = filter(event)
+		if !ok {
+			return LogEvent{}, false
+		}
+	}
+	return event, true
+}
+
+// JSONDetectorFilter returns a LogFilter that, when a line parses as a JSON
+// object, promotes its level, ts/time, msg, and trace_id fields onto the
+// LogEvent. Lines that aren't a JSON object pass through unchanged.
+func JSONDetectorFilter() LogFilter {
+	return func(event LogEvent) (LogEvent, bool) {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(bytes.TrimSpace(event.Raw), &fields); err != nil {
+			return event, true
+		}
+
+		if level, ok := fields["level"].(string); ok {
+			event.Level = level
+		}
+		if msg, ok := fields["msg"].(string); ok {
+			event.Message = msg
+		}
+		if traceID, ok := fields["trace_id"].(string); ok {
+			event.TraceID = traceID
+		}
+		if ts, ok := fields["ts"]; ok {
+			event.Timestamp = parseJSONTimestamp(ts)
+		} else if ts, ok := fields["time"]; ok {
+			event.Timestamp = parseJSONTimestamp(ts)
+		}
+
+		return event, true
+	}
+}
+
+// parseJSONTimestamp accepts either a numeric Unix timestamp (seconds, as
+// JSON numbers decode to float64) or an RFC3339 string, the two forms most
+// structured loggers use for ts/time fields; a string that isn't RFC3339 but
+// parses as a number is read as Unix seconds too.
+func parseJSONTimestamp(v interface{}) time.Time {
+	switch value := v.(type) {
+	case float64:
+		seconds := int64(value)
+		nanos := int64((value - float64(seconds)) * float64(time.Second))
+		return time.Unix(seconds, nanos).UTC()
+	case string:
+		if ts, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return ts
+		}
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			return parseJSONTimestamp(seconds)
+		}
+	}
+	return time.Time{}
+}
+
+// PodMetadataFilter returns a LogFilter that attaches the event's pod's
+// node, pod IP(s), and any of its labels named in labelAllowlist, so
+// downstream sinks can route or index without a second API call.
+func PodMetadataFilter(labelAllowlist []string) LogFilter {
+	return func(event LogEvent) (LogEvent, bool) {
+		if event.Pod == nil {
+			return event, true
+		}
+
+		event.Node = event.Pod.Spec.NodeName
+		event.PodIP = event.Pod.Status.PodIP
+		for _, podIP := range event.Pod.Status.PodIPs {
+			event.PodIPs = append(event.PodIPs, podIP.IP)
+		}
+
+		if len(labelAllowlist) > 0 {
+			labels := make(map[string]string, len(labelAllowlist))
+			for _, key := range labelAllowlist {
+				if value, ok := event.Pod.Labels[key]; ok {
+					labels[key] = value
+				}
+			}
+			event.Labels = labels
+		}
+
+		return event, true
+	}
+}
@@ -0,0 +1,53 @@
+package ktail
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseJSONTimestamp(t *testing.T) {
+	cases := []struct {
+		name string
+		in   interface{}
+		want time.Time
+	}{
+		{
+			name: "unix seconds as float64",
+			in:   float64(1700000000),
+			want: time.Unix(1700000000, 0).UTC(),
+		},
+		{
+			name: "unix seconds with fractional part",
+			in:   1700000000.5,
+			want: time.Unix(1700000000, 500000000).UTC(),
+		},
+		{
+			name: "RFC3339Nano string",
+			in:   "2026-07-29T12:00:00.5Z",
+			want: time.Date(2026, 7, 29, 12, 0, 0, 500000000, time.UTC),
+		},
+		{
+			name: "numeric string falls back to unix seconds",
+			in:   "1700000000",
+			want: time.Unix(1700000000, 0).UTC(),
+		},
+		{
+			name: "unparseable string",
+			in:   "not a timestamp",
+			want: time.Time{},
+		},
+		{
+			name: "unsupported type",
+			in:   true,
+			want: time.Time{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := parseJSONTimestamp(tc.in); !got.Equal(tc.want) {
+				t.Errorf("parseJSONTimestamp(%v) = %v, want %v", tc.in, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package ktail
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+)
+
+func TestJSONDetectorFilterPromotesFields(t *testing.T) {
+	event := LogEvent{Raw: []byte(`{"level":"info","msg":"hello","trace_id":"abc","ts":1700000000}`)}
+
+	got, ok := JSONDetectorFilter()(event)
+	if !ok {
+		t.Fatal("JSONDetectorFilter dropped a well-formed JSON line")
+	}
+	if got.Level != "info" || got.Message != "hello" || got.TraceID != "abc" {
+		t.Fatalf("got %+v, want level=info msg=hello trace_id=abc", got)
+	}
+	if got.Timestamp.Unix() != 1700000000 {
+		t.Errorf("Timestamp = %v, want unix 1700000000", got.Timestamp)
+	}
+}
+
+func TestJSONDetectorFilterPassesThroughNonJSON(t *testing.T) {
+	event := LogEvent{Raw: []byte("plain text log line")}
+
+	got, ok := JSONDetectorFilter()(event)
+	if !ok {
+		t.Fatal("JSONDetectorFilter dropped a non-JSON line")
+	}
+	if got.Level != "" || got.Message != "" || !got.Timestamp.IsZero() {
+		t.Fatalf("got %+v, want a zero-value event unchanged by the filter", got)
+	}
+}
+
+func TestPodMetadataFilterAttachesNodeIPsAndAllowlistedLabels(t *testing.T) {
+	pod := &v1.Pod{
+		Spec:   v1.PodSpec{NodeName: "node-1"},
+		Status: v1.PodStatus{PodIP: "10.0.0.1", PodIPs: []v1.PodIP{{IP: "10.0.0.1"}, {IP: "fd00::1"}}},
+	}
+	pod.Labels = map[string]string{"app": "checkout", "team": "payments", "internal": "secret"}
+
+	event := LogEvent{Pod: pod}
+	got, ok := PodMetadataFilter([]string{"app", "team", "absent"})(event)
+	if !ok {
+		t.Fatal("PodMetadataFilter dropped the event")
+	}
+	if got.Node != "node-1" || got.PodIP != "10.0.0.1" {
+		t.Fatalf("got Node=%q PodIP=%q, want node-1/10.0.0.1", got.Node, got.PodIP)
+	}
+	if len(got.PodIPs) != 2 || got.PodIPs[0] != "10.0.0.1" || got.PodIPs[1] != "fd00::1" {
+		t.Fatalf("got PodIPs=%v, want both pod IPs in order", got.PodIPs)
+	}
+	want := map[string]string{"app": "checkout", "team": "payments"}
+	if len(got.Labels) != len(want) {
+		t.Fatalf("got Labels=%v, want %v (internal and absent excluded)", got.Labels, want)
+	}
+	for k, v := range want {
+		if got.Labels[k] != v {
+			t.Errorf("Labels[%q] = %q, want %q", k, got.Labels[k], v)
+		}
+	}
+}
+
+func TestPodMetadataFilterNilPodPassesThrough(t *testing.T) {
+	got, ok := PodMetadataFilter([]string{"app"})(LogEvent{})
+	if !ok {
+		t.Fatal("PodMetadataFilter dropped an event with a nil Pod")
+	}
+	if got.Node != "" || got.PodIP != "" || got.PodIPs != nil || got.Labels != nil {
+		t.Fatalf("got %+v, want a zero-value event unchanged", got)
+	}
+}
+
+func TestRunFiltersChainsInOrderAndStopsOnDrop(t *testing.T) {
+	ctl := &Controller{}
+	ctl.AddLogFilter(func(event LogEvent) (LogEvent, bool) {
+		event.Message = "first"
+		return event, true
+	})
+	ctl.AddLogFilter(func(event LogEvent) (LogEvent, bool) {
+		event.Message += "+second"
+		return event, true
+	})
+
+	got, ok := ctl.runFilters(LogEvent{})
+	if !ok {
+		t.Fatal("runFilters dropped a line no filter rejected")
+	}
+	if got.Message != "first+second" {
+		t.Fatalf("Message = %q, want filters applied in registration order", got.Message)
+	}
+
+	ctl.AddLogFilter(func(event LogEvent) (LogEvent, bool) { return LogEvent{}, false })
+	ctl.AddLogFilter(func(event LogEvent) (LogEvent, bool) {
+		t.Fatal("a later filter ran after an earlier one dropped the line")
+		return event, true
+	})
+
+	if _, ok := ctl.runFilters(LogEvent{}); ok {
+		t.Fatal("runFilters did not drop a line a filter rejected")
+	}
+}
+
+func TestJSONDetectorThenPodMetadataChain(t *testing.T) {
+	ctl := &Controller{}
+	ctl.AddLogFilter(JSONDetectorFilter())
+	ctl.AddLogFilter(PodMetadataFilter([]string{"app"}))
+
+	pod := &v1.Pod{Spec: v1.PodSpec{NodeName: "node-1"}}
+	pod.Labels = map[string]string{"app": "checkout"}
+
+	event := LogEvent{
+		Pod: pod,
+		Raw: []byte(`{"level":"warn","msg":"disk low"}`),
+	}
+	got, ok := ctl.runFilters(event)
+	if !ok {
+		t.Fatal("runFilters dropped a line neither filter rejects")
+	}
+	if got.Level != "warn" || got.Message != "disk low" {
+		t.Fatalf("JSON fields not promoted: got %+v", got)
+	}
+	if got.Node != "node-1" || got.Labels["app"] != "checkout" {
+		t.Fatalf("pod metadata not attached: got %+v", got)
+	}
+}
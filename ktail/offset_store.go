@@ -0,0 +1,16 @@
+package ktail
+
+import "time"
+
+// OffsetStore persists the last-delivered-log-line timestamp for a
+// container so a Controller can resume tailing from where it left off
+// across restarts of the controller process itself, not just a single
+// stream reconnect. The in-memory high-water-mark map a Controller always
+// keeps is consulted first; a non-nil OffsetStore backs it with on-disk (or
+// otherwise durable) storage.
+type OffsetStore interface {
+	// Get returns the last recorded timestamp for key, if any.
+	Get(key string) (ts time.Time, ok bool, err error)
+	// Set records ts as the last delivered timestamp for key.
+	Set(key string, ts time.Time) error
+}